@@ -0,0 +1,289 @@
+package merkledag
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Chunker splits a byte stream into content chunks. Next returns io.EOF once
+// the underlying reader is exhausted, and a nil error with a non-empty chunk
+// otherwise.
+type Chunker interface {
+	Next() ([]byte, error)
+}
+
+// FixedChunker reproduces the original behaviour of handleFile: every chunk
+// is exactly Size bytes, except possibly the last one.
+type FixedChunker struct {
+	r    io.Reader
+	Size int
+}
+
+// NewFixedChunker returns a Chunker that splits r into fixed-size chunks.
+func NewFixedChunker(r io.Reader, size int) *FixedChunker {
+	return &FixedChunker{r: r, Size: size}
+}
+
+func (c *FixedChunker) Next() ([]byte, error) {
+	buf := make([]byte, c.Size)
+	n, err := io.ReadFull(c.r, buf)
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+const (
+	// rabinPoly is the 53-bit irreducible polynomial (over GF(2)) that
+	// drives the rolling fingerprint, the same degree class used by
+	// restic's default chunker polynomial.
+	rabinPoly       uint64 = 0x3DA3358B4DC173
+	rabinPolyDegree uint   = 53
+	rabinWindowSize        = 64
+
+	// Defaults mirror restic: 512 KB / 1 MB / 4 MB.
+	RabinDefaultMin = 512 * KB
+	RabinDefaultAvg = 1024 * KB
+	RabinDefaultMax = 4096 * KB
+)
+
+// rabinTables holds the two lookup tables that let RabinChunker update its
+// fingerprint with one shift, two table lookups and one xor per byte,
+// instead of recomputing the polynomial division from scratch.
+type rabinTables struct {
+	out [256]uint64 // contribution removed when a byte leaves the window
+	mod [256]uint64 // reduction of the fingerprint modulo rabinPoly
+}
+
+func newRabinTables(pol uint64, degree uint) *rabinTables {
+	t := &rabinTables{}
+	top := uint64(1) << (degree - 1)
+	shiftReduce := func(h uint64) uint64 {
+		if h&top != 0 {
+			return (h << 1) ^ pol
+		}
+		return h << 1
+	}
+	// out[b] must cancel exactly the contribution byte b still has once it's
+	// about to slide out of a rabinWindowSize-byte window, i.e. b shifted up
+	// by rabinWindowSize whole bytes and reduced mod pol — not just one byte
+	// (8 shift-reduce steps), or the window never actually empties and the
+	// fingerprint keeps depending on bytes from long before the window.
+	for b := 0; b < 256; b++ {
+		h := uint64(b)
+		for i := 0; i < rabinWindowSize*8; i++ {
+			h = shiftReduce(h)
+		}
+		t.out[b] = h
+	}
+	// mod[b] clears the byte b that overflowed above bit degree-1 and adds
+	// back b*x^degree reduced mod pol.
+	for b := 0; b < 256; b++ {
+		h := uint64(b)
+		for i := uint(0); i < degree; i++ {
+			h = shiftReduce(h)
+		}
+		t.mod[b] = h ^ uint64(b)<<degree
+	}
+	return t
+}
+
+var defaultRabinTables = newRabinTables(rabinPoly, rabinPolyDegree)
+
+// RabinChunker implements content-defined chunking: it maintains a rolling
+// polynomial fingerprint over a 64-byte sliding window and cuts a chunk
+// whenever the fingerprint matches a target mask, so inserting or deleting a
+// byte inside a file only reshuffles the chunk boundaries around the edit
+// instead of every chunk after it.
+type RabinChunker struct {
+	r             io.Reader
+	Min, Avg, Max int
+	mask          uint64
+	tables        *rabinTables
+	window        [rabinWindowSize]byte
+	wpos          int
+	fp            uint64
+	buf           []byte
+	bpos, bmax    int
+	eof           bool
+}
+
+func log2(n int) uint {
+	var p uint
+	for (1 << p) < n {
+		p++
+	}
+	return p
+}
+
+// NewRabinChunker returns a content-defined Chunker over r. min/avg/max are
+// in bytes; min and max bound every cut, avg sets the target chunk size via
+// mask = (1<<log2(avg)) - 1.
+func NewRabinChunker(r io.Reader, min, avg, max int) *RabinChunker {
+	return &RabinChunker{
+		r:      r,
+		Min:    min,
+		Avg:    avg,
+		Max:    max,
+		mask:   (uint64(1) << log2(avg)) - 1,
+		tables: defaultRabinTables,
+		buf:    make([]byte, 64*KB),
+	}
+}
+
+func (c *RabinChunker) readByte() (byte, error) {
+	if c.bpos >= c.bmax {
+		if c.eof {
+			return 0, io.EOF
+		}
+		n, err := c.r.Read(c.buf)
+		c.bpos, c.bmax = 0, n
+		if n == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		if err == io.EOF {
+			c.eof = true
+		}
+	}
+	b := c.buf[c.bpos]
+	c.bpos++
+	return b, nil
+}
+
+// Next resets the rolling window and fingerprint at the start of every
+// chunk. Since the fingerprint only covers the last rabinWindowSize bytes,
+// a cut decision made at least that far into a chunk depends only on those
+// bytes, so inserting a byte in a file moves only the boundaries near it.
+func (c *RabinChunker) Next() ([]byte, error) {
+	c.window = [rabinWindowSize]byte{}
+	c.wpos = 0
+	c.fp = 0
+
+	var chunk []byte
+	for {
+		b, err := c.readByte()
+		if err != nil {
+			if err == io.EOF {
+				if len(chunk) == 0 {
+					return nil, io.EOF
+				}
+				return chunk, nil
+			}
+			return nil, err
+		}
+		chunk = append(chunk, b)
+
+		out := c.window[c.wpos]
+		c.window[c.wpos] = b
+		c.wpos = (c.wpos + 1) % rabinWindowSize
+
+		c.fp = (c.fp << 8) | uint64(b)
+		c.fp ^= c.tables.out[out]
+		c.fp ^= c.tables.mod[(c.fp>>rabinPolyDegree)&0xff]
+
+		if len(chunk) < c.Min {
+			continue
+		}
+		if len(chunk) >= c.Max {
+			return chunk, nil
+		}
+		if c.fp&c.mask == 0 {
+			return chunk, nil
+		}
+	}
+}
+
+// buildFileTree drains ck chunk by chunk and builds one Object per level of
+// the file's link tree, bottom-up. Each level buffers at most MaxListLine
+// pending links before being flushed into the level above it, so memory
+// stays bounded regardless of how many chunks the file splits into. When
+// crypt and dek are set, every leaf blob is AES-CTR encrypted before being
+// stored and its Link records the IV needed to decrypt it again.
+func buildFileTree(ck Chunker, store KVStore, h hash.Hash, crypt Crypt, dek []byte) (*Object, error) {
+	levels := []*Object{{}}
+	levelSize := []int64{0}
+
+	var promote func(lvl int)
+	var appendLink func(lvl int, link *Link, size int64, linkType string)
+
+	appendLink = func(lvl int, link *Link, size int64, linkType string) {
+		for lvl >= len(levels) {
+			levels = append(levels, &Object{})
+			levelSize = append(levelSize, 0)
+		}
+		levels[lvl].Links = append(levels[lvl].Links, link)
+		levels[lvl].Data = append(levels[lvl].Data, []byte(linkType)...)
+		levelSize[lvl] += size
+		if len(levels[lvl].Links) >= MaxListLine {
+			promote(lvl)
+		}
+	}
+
+	promote = func(lvl int) {
+		full := levels[lvl]
+		size := levelSize[lvl]
+		putObjInStore(full, store, h)
+		jsonMarshal, _ := json.Marshal(full)
+		levels[lvl] = &Object{}
+		levelSize[lvl] = 0
+		appendLink(lvl+1, &Link{
+			Hash: computeHash(*full, jsonMarshal, h),
+			Size: int(size),
+		}, size, LINK)
+	}
+
+	for {
+		chunk, err := ck.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobData := chunk
+		var iv []byte
+		if crypt != nil && len(dek) > 0 {
+			iv = make([]byte, IVSize)
+			rand.Read(iv)
+			enc, encErr := crypt.Encrypt(chunk, dek, iv)
+			if encErr != nil {
+				fmt.Println("encrypt err:", encErr)
+				iv = nil
+			} else {
+				blobData = enc
+			}
+		}
+		blobObj := &Object{Data: blobData}
+		putObjInStore(blobObj, store, h)
+		jsonMarshal, _ := json.Marshal(blobObj)
+		appendLink(0, &Link{
+			Hash: computeHash(*blobObj, jsonMarshal, h),
+			Size: len(chunk),
+			IV:   iv,
+		}, int64(len(chunk)), BLOB)
+	}
+
+	// Flush every partially-filled level into the one above it, so the
+	// result is a single root Object.
+	for lvl := 0; lvl < len(levels)-1; lvl++ {
+		if len(levels[lvl].Links) > 0 {
+			promote(lvl)
+		}
+	}
+
+	top := levels[len(levels)-1]
+	putObjInStore(top, store, h)
+	return top, nil
+}
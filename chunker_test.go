@@ -0,0 +1,80 @@
+package merkledag
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func chunkAll(t *testing.T, ck Chunker) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	for {
+		c, err := ck.Next()
+		if len(c) > 0 {
+			chunks = append(chunks, append([]byte(nil), c...))
+		}
+		if err != nil {
+			break
+		}
+	}
+	return chunks
+}
+
+func sharedChunks(a, b [][]byte) int {
+	seen := make(map[string]int)
+	for _, c := range a {
+		seen[string(c)]++
+	}
+	shared := 0
+	for _, c := range b {
+		if seen[string(c)] > 0 {
+			seen[string(c)]--
+			shared++
+		}
+	}
+	return shared
+}
+
+// TestRabinChunkerShiftResistant checks the core content-defined chunking
+// promise: inserting a byte at the front of the file only reshuffles the
+// chunks around the edit, unlike FixedChunker where every chunk after the
+// insertion point shifts.
+func TestRabinChunkerShiftResistant(t *testing.T) {
+	const size = 2 * 1024 * 1024
+	src := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(src)
+	shifted := append([]byte{0xAA}, src...)
+
+	min, avg, max := 4*KB, 16*KB, 64*KB
+
+	rabinBase := chunkAll(t, NewRabinChunker(bytes.NewReader(src), min, avg, max))
+	rabinShifted := chunkAll(t, NewRabinChunker(bytes.NewReader(shifted), min, avg, max))
+	if shared := sharedChunks(rabinBase, rabinShifted); shared < len(rabinBase)/2 {
+		t.Fatalf("RabinChunker: only %d/%d chunks survived a 1-byte prepend", shared, len(rabinBase))
+	}
+
+	fixedBase := chunkAll(t, NewFixedChunker(bytes.NewReader(src), avg))
+	fixedShifted := chunkAll(t, NewFixedChunker(bytes.NewReader(shifted), avg))
+	if shared := sharedChunks(fixedBase, fixedShifted); shared != 0 {
+		t.Fatalf("expected FixedChunker to share no chunks after a prepend, got %d", shared)
+	}
+}
+
+// TestRabinChunkerAverageSize confirms the mask derived from Avg actually
+// produces chunks clustered around that target, not some multiple of it.
+func TestRabinChunkerAverageSize(t *testing.T) {
+	const size = 8 * 1024 * 1024
+	src := make([]byte, size)
+	rand.New(rand.NewSource(2)).Read(src)
+
+	min, avg, max := 4*KB, 16*KB, 64*KB
+	chunks := chunkAll(t, NewRabinChunker(bytes.NewReader(src), min, avg, max))
+	if len(chunks) == 0 {
+		t.Fatal("no chunks produced")
+	}
+	mean := size / len(chunks)
+	if mean < avg/2 || mean > avg*2 {
+		t.Fatalf("mean chunk size %d is not within 2x of Avg=%d (got %d chunks)", mean, avg, len(chunks))
+	}
+}
@@ -0,0 +1,133 @@
+package merkledag
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"hash"
+)
+
+// Crypt is the pluggable hash/encrypt/sign suite used to protect blobs and
+// sign Merkle roots. Every Add call that wants encryption or signing goes
+// through a single Crypt so the hash, cipher and signature algorithm can't
+// be mixed across a tree by accident.
+type Crypt interface {
+	Hash(data []byte) []byte
+	NewHash() hash.Hash
+	Encrypt(data, key, iv []byte) ([]byte, error)
+	Decrypt(data, key, iv []byte) ([]byte, error)
+	Sign(data, priKey []byte) ([]byte, error)
+	Verify(data, signature, pubKey []byte) bool
+	EncryptE(data, pubKey []byte) ([]byte, error)
+	DecryptE(data, priKey []byte) ([]byte, error)
+}
+
+// IVSize is the AES-CTR nonce size SHA256AESRSA expects for Encrypt/Decrypt.
+const IVSize = aes.BlockSize
+
+// SHA256AESRSA is the default Crypt suite: SHA-256 content hashing, AES-CTR
+// for symmetric blob encryption and RSA (PKCS1v15) for signing and key
+// wrapping.
+type SHA256AESRSA struct{}
+
+func (SHA256AESRSA) Hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func (SHA256AESRSA) NewHash() hash.Hash {
+	return sha256.New()
+}
+
+func (SHA256AESRSA) Encrypt(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(out, data)
+	return out, nil
+}
+
+// Decrypt is identical to Encrypt: AES-CTR is its own inverse.
+func (c SHA256AESRSA) Decrypt(data, key, iv []byte) ([]byte, error) {
+	return c.Encrypt(data, key, iv)
+}
+
+func (SHA256AESRSA) Sign(data, priKeyBytes []byte) ([]byte, error) {
+	priKey, err := x509.ParsePKCS1PrivateKey(priKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, priKey, 0, digest[:])
+}
+
+func (SHA256AESRSA) Verify(data, signature, pubKeyBytes []byte) bool {
+	pubKey, err := x509.ParsePKCS1PublicKey(pubKeyBytes)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(pubKey, 0, digest[:], signature) == nil
+}
+
+func (SHA256AESRSA) EncryptE(data, pubKeyBytes []byte) ([]byte, error) {
+	pubKey, err := x509.ParsePKCS1PublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.EncryptPKCS1v15(rand.Reader, pubKey, data)
+}
+
+func (SHA256AESRSA) DecryptE(data, priKeyBytes []byte) ([]byte, error) {
+	priKey, err := x509.ParsePKCS1PrivateKey(priKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.DecryptPKCS1v15(rand.Reader, priKey, data)
+}
+
+// AddSigned is AddWithOptions plus a signature over the resulting root: it
+// builds the tree using crypt for hashing, then signs the marshaled root
+// Object with priKey so a holder of the matching public key can attest the
+// tree wasn't swapped for a different one. It passes no DEK, so the blobs
+// it stores are not encrypted.
+func AddSigned(store KVStore, node Node, h hash.Hash, crypt Crypt, priKey []byte) (root, signature []byte) {
+	root = AddWithOptions(store, node, h, &AddOptions{Crypt: crypt})
+	rootObj := getObjectByHash(store, root)
+	data, _ := json.Marshal(rootObj)
+	signature, _ = crypt.Sign(data, priKey)
+	return root, signature
+}
+
+// VerifyRoot checks signature against the marshaled root Object and then
+// walks the whole tree making sure every child's stored bytes still hash to
+// the value its parent Link claims.
+func VerifyRoot(store KVStore, root, signature, pubKey []byte, crypt Crypt) bool {
+	rootObj := getObjectByHash(store, root)
+	data, _ := json.Marshal(rootObj)
+	if !crypt.Verify(data, signature, pubKey) {
+		return false
+	}
+	return verifyLinks(store, rootObj, crypt)
+}
+
+func verifyLinks(store KVStore, obj *Object, crypt Crypt) bool {
+	for _, link := range obj.Links {
+		child := getObjectByHash(store, link.Hash)
+		childJSON, _ := json.Marshal(child)
+		if !bytes.Equal(computeHash(*child, childJSON, crypt.NewHash()), link.Hash) {
+			return false
+		}
+		if child.Links != nil && !verifyLinks(store, child, crypt) {
+			return false
+		}
+	}
+	return true
+}
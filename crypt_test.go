@@ -0,0 +1,182 @@
+package merkledag
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+)
+
+func TestSHA256AESRSAEncryptDecryptRoundTrip(t *testing.T) {
+	crypt := SHA256AESRSA{}
+	key := make([]byte, 32)
+	rand.Read(key)
+	iv := make([]byte, IVSize)
+	rand.Read(iv)
+
+	for name, plain := range map[string][]byte{
+		"small": []byte("a short secret"),
+		"large": make([]byte, 5*1024*1024),
+	} {
+		if name == "large" {
+			rand.Read(plain)
+		}
+		enc, err := crypt.Encrypt(plain, key, iv)
+		if err != nil {
+			t.Fatalf("%s: Encrypt: %v", name, err)
+		}
+		if bytes.Equal(enc, plain) {
+			t.Fatalf("%s: ciphertext equals plaintext", name)
+		}
+		dec, err := crypt.Decrypt(enc, key, iv)
+		if err != nil {
+			t.Fatalf("%s: Decrypt: %v", name, err)
+		}
+		if !bytes.Equal(dec, plain) {
+			t.Fatalf("%s: round trip mismatch", name)
+		}
+	}
+}
+
+func newTestRSAKeys(t *testing.T) (priv, pub []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return x509.MarshalPKCS1PrivateKey(key), x509.MarshalPKCS1PublicKey(&key.PublicKey)
+}
+
+func TestSHA256AESRSASignVerify(t *testing.T) {
+	crypt := SHA256AESRSA{}
+	priv, pub := newTestRSAKeys(t)
+	data := []byte("root object bytes")
+
+	sig, err := crypt.Sign(data, priv)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !crypt.Verify(data, sig, pub) {
+		t.Fatal("Verify rejected a genuine signature")
+	}
+	if crypt.Verify([]byte("tampered bytes"), sig, pub) {
+		t.Fatal("Verify accepted a signature over different data")
+	}
+}
+
+// TestHash2FileDecryptSmallFile is a regression test: a file <= ChunkSize is
+// stored as a single encrypted blob Object with no Links of its own, and its
+// IV lives on the directory Link that points at it, not on the blob.
+func TestHash2FileDecryptSmallFile(t *testing.T) {
+	store := newMemKVStore()
+	crypt := SHA256AESRSA{}
+	h := crypt.NewHash()
+	dek := make([]byte, 32)
+	rand.Read(dek)
+
+	plain := []byte("small secret file contents")
+	iv := make([]byte, IVSize)
+	rand.Read(iv)
+	enc, err := crypt.Encrypt(plain, dek, iv)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	leaf := &Object{Data: enc}
+	putObjInStore(leaf, store, h)
+	leafJSON, _ := json.Marshal(leaf)
+	leafHash := computeHash(*leaf, leafJSON, h)
+
+	dir := &Object{
+		Links: []*Link{{Name: "secret.txt", Hash: leafHash, IV: iv, Size: len(plain)}},
+		Data:  []byte(BLOB),
+	}
+	putObjInStore(dir, store, h)
+	dirJSON, _ := json.Marshal(dir)
+	root := computeHash(*dir, dirJSON, h)
+
+	got := Hash2FileDecrypt(store, root, "secret.txt", fixedHashPool{}, crypt, dek)
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("Hash2FileDecrypt small file = %q, want %q", got, plain)
+	}
+}
+
+// TestHash2FileDecryptLargeFile covers the multi-chunk path, where each
+// chunk's own Link (not the directory's) carries the IV.
+func TestHash2FileDecryptLargeFile(t *testing.T) {
+	store := newMemKVStore()
+	crypt := SHA256AESRSA{}
+	h := crypt.NewHash()
+	dek := make([]byte, 32)
+	rand.Read(dek)
+
+	plain := make([]byte, 3*1024*1024)
+	rand.Read(plain)
+
+	fileObj, err := buildFileTree(NewFixedChunker(bytes.NewReader(plain), 64*KB), store, h, crypt, dek)
+	if err != nil {
+		t.Fatalf("buildFileTree: %v", err)
+	}
+	fileJSON, _ := json.Marshal(fileObj)
+	fileHash := computeHash(*fileObj, fileJSON, h)
+
+	dir := &Object{
+		Links: []*Link{{Name: "bigsecret", Hash: fileHash, Size: len(plain)}},
+		Data:  []byte(LINK),
+	}
+	putObjInStore(dir, store, h)
+	dirJSON, _ := json.Marshal(dir)
+	root := computeHash(*dir, dirJSON, h)
+
+	got := Hash2FileDecrypt(store, root, "bigsecret", fixedHashPool{}, crypt, dek)
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("Hash2FileDecrypt large file round trip mismatch: got %d bytes, want %d", len(got), len(plain))
+	}
+}
+
+// TestAddSignedVerifyRoot exercises VerifyRoot the same way AddSigned
+// produces its input: a root Object whose marshaled bytes are signed, and
+// whose children must each still hash to what their parent Link claims.
+func TestAddSignedVerifyRoot(t *testing.T) {
+	store := newMemKVStore()
+	crypt := SHA256AESRSA{}
+	h := crypt.NewHash()
+	priv, pub := newTestRSAKeys(t)
+
+	leafA := &Object{Data: []byte("file a")}
+	putObjInStore(leafA, store, h)
+	leafAJSON, _ := json.Marshal(leafA)
+	leafB := &Object{Data: []byte("file b")}
+	putObjInStore(leafB, store, h)
+	leafBJSON, _ := json.Marshal(leafB)
+
+	root := &Object{
+		Links: []*Link{
+			{Name: "a", Hash: computeHash(*leafA, leafAJSON, h)},
+			{Name: "b", Hash: computeHash(*leafB, leafBJSON, h)},
+		},
+		Data: []byte(BLOB + BLOB),
+	}
+	putObjInStore(root, store, h)
+	rootJSON, _ := json.Marshal(root)
+	rootHash := computeHash(*root, rootJSON, h)
+
+	signature, err := crypt.Sign(rootJSON, priv)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	if !VerifyRoot(store, rootHash, signature, pub, crypt) {
+		t.Fatal("VerifyRoot rejected a valid signed tree")
+	}
+
+	// Tamper with a leaf's stored bytes without updating its parent Link:
+	// VerifyRoot's tree walk must catch the mismatch even though the
+	// signature over the (unchanged) root is still valid.
+	store.m[string(computeHash(*leafA, leafAJSON, h))] = []byte(`{"Links":null,"Data":"dGFtcGVyZWQ="}`)
+	if VerifyRoot(store, rootHash, signature, pub, crypt) {
+		t.Fatal("VerifyRoot accepted a tree with a tampered leaf")
+	}
+}
@@ -1,10 +1,12 @@
 package merkledag
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"hash"
-	"math"
+	"io"
 )
 
 const (
@@ -14,92 +16,203 @@ const (
 	BLOB        = "blob"
 	LINK        = "link"
 	TREE        = "tree"
+	HAMT        = "hamt"
+
+	// ShardThreshold is the child count past which handleDir switches a
+	// directory from a flat TREE to a HAMT-sharded one.
+	ShardThreshold = 256
 )
 
 type Link struct {
 	Name string
 	Hash []byte
 	Size int
+	IV   []byte // AES-CTR nonce, set when the object this Link points to is encrypted
 }
 
 type Object struct {
 	Links []*Link // 使用指针切片存储Link
 	Data  []byte
+	// Bitmap marks which of the 16 nibble slots are populated in a HAMT
+	// shard (see handleDir/getNode). Zero and omitted for every other
+	// Object kind.
+	Bitmap uint16 `json:",omitempty"`
+}
+
+// StreamFile is implemented by a File that can hand back its content as an
+// io.Reader. handleFile prefers it over File.Bytes() so large files are
+// chunked off the wire instead of being buffered whole before chunking.
+type StreamFile interface {
+	File
+	Open() (io.Reader, error)
+}
+
+// AddOptions configures how Add splits and protects file content. A nil
+// NewChunker falls back to the original fixed ChunkSize split, and a nil
+// Crypt leaves blobs unencrypted, so existing callers of Add see no change
+// in behaviour.
+type AddOptions struct {
+	NewChunker func(r io.Reader) Chunker
+	Crypt      Crypt  // when set, leaf blobs are AES-CTR encrypted with DEK
+	DEK        []byte // data-encryption key; required if Crypt is set and encryption is wanted
+}
+
+// addCtx is the resolved form of AddOptions threaded through handleFile/
+// handleDir/buildFileTree, so those functions don't each re-derive defaults.
+type addCtx struct {
+	newChunker func(io.Reader) Chunker
+	crypt      Crypt
+	dek        []byte
+}
+
+func defaultChunker(r io.Reader) Chunker {
+	return NewFixedChunker(r, ChunkSize)
 }
 
 func Add(store KVStore, node Node, h hash.Hash) []byte {
+	return AddWithOptions(store, node, h, nil)
+}
+
+// AddWithOptions is Add with control over the chunker and the Crypt suite
+// used to split and optionally encrypt files. When opts.Crypt is set, h is
+// ignored in favour of opts.Crypt.NewHash() so a caller can't accidentally
+// mix an unrelated hash algorithm into an encrypted tree.
+func AddWithOptions(store KVStore, node Node, h hash.Hash, opts *AddOptions) []byte {
 	// TODO 将分片写入到KVStore中，并返回Merkle Root
+	ctx := &addCtx{newChunker: defaultChunker}
+	if opts != nil {
+		if opts.NewChunker != nil {
+			ctx.newChunker = opts.NewChunker
+		}
+		ctx.crypt = opts.Crypt
+		ctx.dek = opts.DEK
+	}
+	if ctx.crypt != nil {
+		h = ctx.crypt.NewHash()
+	}
 	var obj Object
 	switch node.Type() {
 	case FILE:
-		obj = *handleFile(node, store, h) // 解引用指针获取实际对象
+		tmp, _ := handleFile(node, store, h, ctx)
+		obj = *tmp // 解引用指针获取实际对象
 		break
 	case DIR:
-		obj = *handleDir(node, store, h) // 解引用指针获取实际对象
+		obj = *handleDir(node, store, h, ctx) // 解引用指针获取实际对象
 		break
 	}
 	JsonObj, _ := json.Marshal(obj)
 	return computeHash(obj, JsonObj, h)
 }
 
-func handleFile(node Node, store KVStore, h hash.Hash) *Object {
-	obj := &Object{} // 使用指针类型的Object
+// fileReader returns an io.Reader over FileNode's content. FileNode.Bytes()
+// is only used as a last resort, since it forces the whole file into memory;
+// a File that also implements StreamFile is read from directly instead.
+//
+// Note that the bounded-memory guarantee buildFileTree otherwise provides
+// only holds for StreamFile implementations: Bytes() itself has to return
+// the complete file before fileReader ever runs, so a plain File is already
+// fully buffered by its own contract by the time chunking starts. Wrapping
+// that returned slice in a chunked reader here wouldn't change that — the
+// allocation already happened inside Bytes(). Fixing this for good requires
+// a File whose Bytes() (or whatever replaces it) is itself streaming, which
+// is outside this package.
+func fileReader(FileNode File) io.Reader {
+	if sf, ok := FileNode.(StreamFile); ok {
+		if r, err := sf.Open(); err == nil {
+			return r
+		}
+	}
+	return bytes.NewReader(FileNode.Bytes())
+}
+
+// handleFile returns the file's Object plus the IV it was encrypted under,
+// if any. The IV travels back up to handleDir, which is the one that owns
+// the Link pointing at this Object.
+func handleFile(node Node, store KVStore, h hash.Hash, ctx *addCtx) (*Object, []byte) {
 	FileNode, _ := node.(File)
 	if FileNode.Size() > ChunkSize {
-		numChunks := math.Ceil(float64(FileNode.Size()) / float64(ChunkSize))
-		height := 0
-		tmp := numChunks
-		for {
-			height++
-			tmp /= MaxListLine
-			if tmp == 0 {
-				break
-			}
+		obj, err := buildFileTree(ctx.newChunker(fileReader(FileNode)), store, h, ctx.crypt, ctx.dek)
+		if err != nil {
+			fmt.Println("buildFileTree err:", err)
+			return &Object{}, nil
+		}
+		return obj, nil
+	}
+
+	r := fileReader(FileNode)
+	data := make([]byte, FileNode.Size())
+	io.ReadFull(r, data)
+
+	obj := &Object{}
+	var iv []byte
+	if ctx.crypt != nil && len(ctx.dek) > 0 {
+		iv = make([]byte, IVSize)
+		rand.Read(iv)
+		enc, err := ctx.crypt.Encrypt(data, ctx.dek, iv)
+		if err != nil {
+			fmt.Println("encrypt err:", err)
+			iv = nil
+			enc = data
 		}
-		obj, _ = dfsHandleFile(height, FileNode, store, 0, h) // 直接返回指针
+		obj.Data = enc
 	} else {
-		obj.Data = FileNode.Bytes()
-		putObjInStore(obj, store, h)
+		obj.Data = data
 	}
-	return obj
+	putObjInStore(obj, store, h)
+	return obj, iv
 }
 
-func handleDir(node Node, store KVStore, h hash.Hash) *Object {
+func handleDir(node Node, store KVStore, h hash.Hash, ctx *addCtx) *Object {
 	dirNode, _ := node.(Dir)
 	iter := dirNode.It()
-	treeObject := &Object{} // 使用指针类型的Object
+	var children []*dirEntry
 	for iter.Next() {
 		node := iter.Node()
 		switch node.Type() {
 		case FILE:
 			file := node.(File)
-			tmp := handleFile(node, store, h)
+			tmp, iv := handleFile(node, store, h, ctx)
 			jsonMarshal, _ := json.Marshal(tmp)
-			treeObject.Links = append(treeObject.Links, &Link{ // 存储Link指针
+			link := &Link{ // 存储Link指针
 				Hash: computeHash(*tmp, jsonMarshal, h),
 				Size: int(file.Size()),
 				Name: file.Name(),
-			})
+				IV:   iv,
+			}
+			marker := LINK
 			if tmp.Links == nil {
-				treeObject.Data = append(treeObject.Data, []byte(BLOB)...)
-			} else {
-				treeObject.Data = append(treeObject.Data, []byte(LINK)...)
+				marker = BLOB
 			}
-
+			children = append(children, &dirEntry{link: link, marker: marker})
 			break
 		case DIR:
 			dir := node.(Dir)
-			tmp := handleDir(node, store, h)
+			tmp := handleDir(node, store, h, ctx)
 			jsonMarshal, _ := json.Marshal(tmp)
-			treeObject.Links = append(treeObject.Links, &Link{ // 存储Link指针
+			link := &Link{ // 存储Link指针
 				Hash: computeHash(*tmp, jsonMarshal, h),
 				Size: int(dir.Size()),
 				Name: dir.Name(),
-			})
-			treeObject.Data = append(treeObject.Data, []byte(TREE)...)
+			}
+			children = append(children, &dirEntry{link: link, marker: TREE})
 			break
 		}
 	}
+
+	// A directory with more entries than ShardThreshold becomes a HAMT:
+	// getNode hashes the requested name and descends by nibble instead of
+	// scanning every Link in a single huge Object.
+	if len(children) > ShardThreshold {
+		shard := buildHamtShard(children, 0, h, store)
+		putObjInStore(shard, store, h)
+		return shard
+	}
+
+	treeObject := &Object{}
+	for _, c := range children {
+		treeObject.Links = append(treeObject.Links, c.link)
+		treeObject.Data = append(treeObject.Data, []byte(c.marker)...)
+	}
 	putObjInStore(treeObject, store, h)
 	return treeObject
 }
@@ -120,60 +233,6 @@ func computeHash(obj Object, data []byte, h hash.Hash) []byte {
 	return h.Sum(nil)
 }
 
-func dfsHandleFile(height int, node File, store KVStore, start int, h hash.Hash) (*Object, int) {
-	obj := &Object{} // 使用指针类型的Object
-	lenData := 0
-	// 处理多层分片
-	for i := 1; i <= MaxListLine && start < len(node.Bytes()); i++ {
-		var tmpObj *Object // 使用指针类型的Object
-		var tmpDataLen int
-
-		if height > 1 {
-			// 递归处理下一层数据
-			tmpObj, tmpDataLen = dfsHandleFile(height-1, node, store, start, h)
-		} else {
-			// 处理当前层数据
-			end := start + ChunkSize
-			if end > len(node.Bytes()) {
-				end = len(node.Bytes())
-			}
-			data := node.Bytes()[start:end]
-			// 将数据存储到 KVStore
-			blobObj := &Object{ // 使用指针类型的Object
-				Links: nil,
-				Data:  data,
-			}
-			putObjInStore(blobObj, store, h)
-			// 更新 obj 中的 Links 和 Data
-			jsonMarshal, _ := json.Marshal(blobObj)
-			obj.Links = append(obj.Links, &Link{ // 存储Link指针
-				Hash: computeHash(*blobObj, jsonMarshal, h),
-				Size: len(data),
-			})
-
-			obj.Data = append(obj.Data, []byte(BLOB)...)
-			tmpDataLen = len(data)
-			start += ChunkSize
-		}
-
-		lenData += tmpDataLen
-		jsonMarshal, _ := json.Marshal(tmpObj)
-		obj.Links = append(obj.Links, &Link{ // 存储Link指针
-			Hash: computeHash(*tmpObj, jsonMarshal, h),
-			Size: tmpDataLen,
-		})
-		obj.Data = append(obj.Data, []byte(LINK)...)
-
-		if start >= len(node.Bytes()) {
-			break
-		}
-	}
-
-	// 将处理好的对象存储到 KVStore
-	putObjInStore(obj, store, h)
-	return obj, lenData
-}
-
 func putObjInStore(obj *Object, store KVStore, h hash.Hash) {
 	value, err := json.Marshal(obj)
 	if err != nil {
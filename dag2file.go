@@ -1,60 +1,196 @@
 package merkledag
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 )
 
 // Hash to file
 
+// Hash2FileStream walks the link tree rooted at root, streams every leaf
+// blob found under path to w in order, and returns the number of bytes
+// written. Every Object below the one path resolves to is checked against
+// the Link that pointed to it (using a hash.Hash borrowed from hp), so a
+// corrupted or tampered blob among them is reported as an error instead of
+// silently returned. The root, the directory Objects walked to reach path
+// and the Object path resolves to are read without that check, so a file
+// stored as a single blob is returned unverified.
+func Hash2FileStream(store KVStore, root []byte, path string, hp HashPool, w io.Writer) (int64, error) {
+	rootObj := getObjectByHash(store, root)
+	obj := getNode(store, path, hp, *rootObj)
+	if obj.Links == nil {
+		n, err := w.Write(obj.Data)
+		return int64(n), err
+	}
+	return streamDfsData(store, obj, hp, w)
+}
+
+func streamDfsData(store KVStore, object Object, hp HashPool, w io.Writer) (int64, error) {
+	var written int64
+	for _, link := range object.Links {
+		child, err := verifiedObject(store, link, hp)
+		if err != nil {
+			return written, err
+		}
+		if child.Links != nil {
+			n, err := streamDfsData(store, *child, hp, w)
+			written += n
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		n, err := w.Write(child.Data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// verifiedObject fetches the Object link points at and confirms its stored
+// bytes still hash to link.Hash before handing it back.
+func verifiedObject(store KVStore, link *Link, hp HashPool) (*Object, error) {
+	value, err := store.Get(link.Hash)
+	if err != nil {
+		return nil, err
+	}
+	var obj Object
+	if err := json.Unmarshal(value, &obj); err != nil {
+		return nil, err
+	}
+	h := hp.Get()
+	got := computeHash(obj, value, h)
+	hp.Put(h)
+	if !bytes.Equal(got, link.Hash) {
+		return nil, fmt.Errorf("merkledag: object %x failed integrity check", link.Hash)
+	}
+	return &obj, nil
+}
+
+// Hash2File is Hash2FileStream buffered into a single []byte.
 func Hash2File(store KVStore, hash []byte, path string, hp HashPool) []byte {
-	// 根据hash和path， 返回对应的文件, hash对应的类型是tree
+	var buf bytes.Buffer
+	if _, err := Hash2FileStream(store, hash, path, hp, &buf); err != nil {
+		fmt.Println("Hash2File err:", err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Hash2FileDecrypt is Hash2File for a tree that was added with encryption
+// via AddWithOptions/AddSigned: every leaf blob along the path is decrypted
+// with dek using the IV recorded on its Link before being appended.
+func Hash2FileDecrypt(store KVStore, hash []byte, path string, hp HashPool, crypt Crypt, dek []byte) []byte {
 	var treeObj Object
 	treeObj = *getObjectByHash(store, hash)
-	obj := getNode(store, path, hp, treeObj)
-	var data []byte
-	//分区数据处理
-	if obj.Links != nil {
-		data = obj.Data
-	} else {
-		getDfsData(store, obj, data)
+	obj, link := getNodeLink(store, path, hp, treeObj)
+	if obj.Links == nil {
+		// A file <= ChunkSize is stored as a single blob Object with no
+		// Links of its own; its IV lives on the Link that pointed at it,
+		// which getNode's Object-only return would otherwise discard.
+		data := obj.Data
+		if crypt != nil && link != nil && link.IV != nil {
+			plain, err := crypt.Decrypt(data, dek, link.IV)
+			if err != nil {
+				fmt.Println("decrypt err:", err)
+			} else {
+				data = plain
+			}
+		}
+		return data
 	}
-	return data
+	return getDfsDataDecrypt(store, obj, crypt, dek, nil)
 }
-func getDfsData(store KVStore, object Object, data []byte) []byte {
-	obj := &Object{}
+
+func getDfsDataDecrypt(store KVStore, object Object, crypt Crypt, dek []byte, data []byte) []byte {
 	for i := 0; i < len(object.Links); i++ {
-		obj = getObjectByHash(store, object.Links[i].Hash)
+		link := object.Links[i]
+		obj := getObjectByHash(store, link.Hash)
 		if obj.Links != nil {
-			getDfsData(store, *obj, data)
-		} else {
-			data = append(data, obj.Data...)
+			data = getDfsDataDecrypt(store, *obj, crypt, dek, data)
+			continue
 		}
+		chunk := obj.Data
+		if crypt != nil && link.IV != nil {
+			plain, err := crypt.Decrypt(chunk, dek, link.IV)
+			if err != nil {
+				fmt.Println("decrypt err:", err)
+			} else {
+				chunk = plain
+			}
+		}
+		data = append(data, chunk...)
 	}
 	return data
 }
+
 func getNode(store KVStore, path string, hp HashPool, object Object) Object {
+	obj, _ := getNodeLink(store, path, hp, object)
+	return obj
+}
+
+// getNodeLink is getNode but also returns the Link that pointed at the final
+// Object, for callers (Hash2FileDecrypt) that need metadata carried on the
+// Link itself, such as the encryption IV, rather than just the Object it
+// resolves to.
+func getNodeLink(store KVStore, path string, hp HashPool, object Object) (Object, *Link) {
+	var link *Link
 	for _, part := range splitPath(path) {
-		var dirHash []byte
-		for i := 0; i < len(object.Links); i++ {
-			if object.Links[i].Name == part {
-				dirHash = object.Links[i].Hash
-				break
-			}
-		}
-		//获取子节点object
-		jsonObj, _ := store.Get(dirHash)
-		var obj Object
-		err := json.Unmarshal(jsonObj, &obj)
-		if err != nil {
-			fmt.Println("解析字符串错误")
-		} else {
-			object = obj
+		object, link = descendDir(store, object, part, hp)
+	}
+	return object, link
+}
+
+// descendDir fetches the child named part out of object, detecting a HAMT
+// shard (see handleDir/buildHamtShard) and descending by nibble instead of
+// scanning every Link when it finds one.
+func descendDir(store KVStore, object Object, part string, hp HashPool) (Object, *Link) {
+	if isHamtNode(object) {
+		return descendHamt(store, object, part, hp, 0)
+	}
+
+	var child *Link
+	for i := 0; i < len(object.Links); i++ {
+		if object.Links[i].Name == part {
+			child = object.Links[i]
+			break
 		}
 	}
+	if child == nil {
+		return Object{}, nil
+	}
+	return *getObjectByHash(store, child.Hash), child
+}
+
+func isHamtNode(object Object) bool {
+	return string(object.Data) == HAMT
+}
+
+func descendHamt(store KVStore, shard Object, name string, hp HashPool, depth int) (Object, *Link) {
+	h := hp.Get()
+	nibble := hashNibble(h, name, depth)
+	hp.Put(h)
+
+	if shard.Bitmap&(1<<uint(nibble)) == 0 {
+		return Object{}, nil
+	}
+	slot := popcount16(shard.Bitmap & (1<<uint(nibble) - 1))
+	child := *getObjectByHash(store, shard.Links[slot].Hash)
+	if isHamtNode(child) {
+		return descendHamt(store, child, name, hp, depth+1)
+	}
 
-	return object
+	for i := 0; i < len(child.Links); i++ {
+		if child.Links[i].Name == name {
+			return *getObjectByHash(store, child.Links[i].Hash), child.Links[i]
+		}
+	}
+	return Object{}, nil
 }
 
 func splitPath(path string) []string {
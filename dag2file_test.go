@@ -0,0 +1,49 @@
+package merkledag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+// TestHash2FileMultiMB is a regression test for the original getDfsData bug:
+// the accumulated file content was built up by value and the result thrown
+// away, so Hash2File always returned nil for anything past a single blob.
+// This drives a multi-MB file (48 chunks of 64 KB under one link level) through
+// Add's tree shape and back out through Hash2File.
+func TestHash2FileMultiMB(t *testing.T) {
+	store := newMemKVStore()
+	h := sha256.New()
+	hp := fixedHashPool{}
+
+	const size = 3 * 1024 * 1024
+	want := make([]byte, size)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+
+	fileObj, err := buildFileTree(NewFixedChunker(bytes.NewReader(want), 64*KB), store, h, nil, nil)
+	if err != nil {
+		t.Fatalf("buildFileTree: %v", err)
+	}
+	fileJSON, _ := json.Marshal(fileObj)
+	fileHash := computeHash(*fileObj, fileJSON, h)
+
+	marker := LINK
+	if fileObj.Links == nil {
+		marker = BLOB
+	}
+	dir := &Object{
+		Links: []*Link{{Name: "bigfile", Hash: fileHash, Size: size}},
+		Data:  []byte(marker),
+	}
+	putObjInStore(dir, store, h)
+	dirJSON, _ := json.Marshal(dir)
+	rootHash := computeHash(*dir, dirJSON, h)
+
+	got := Hash2File(store, rootHash, "bigfile", hp)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Hash2File round-trip mismatch: got %d bytes, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,90 @@
+package merkledag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"testing"
+)
+
+type memKVStore struct {
+	m map[string][]byte
+}
+
+func newMemKVStore() *memKVStore { return &memKVStore{m: make(map[string][]byte)} }
+
+func (s *memKVStore) Put(key, value []byte) error {
+	s.m[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memKVStore) Get(key []byte) ([]byte, error) {
+	return s.m[string(key)], nil
+}
+
+func (s *memKVStore) Has(key []byte) (bool, error) {
+	_, ok := s.m[string(key)]
+	return ok, nil
+}
+
+type fixedHashPool struct{}
+
+func (fixedHashPool) Get() hash.Hash { return sha256.New() }
+func (fixedHashPool) Put(hash.Hash)  {}
+
+// counterReader hands out deterministic content without ever materializing
+// it in one slice, standing in for a multi-GB file: it proves buildFileTree
+// only ever needs MaxListLine links per level in memory at once, not one
+// link per chunk of the whole file.
+type counterReader struct {
+	remaining int
+	next      byte
+}
+
+func (r *counterReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if n > r.remaining {
+		n = r.remaining
+	}
+	for i := 0; i < n; i++ {
+		p[i] = r.next
+		r.next++
+	}
+	r.remaining -= n
+	return n, nil
+}
+
+// TestBuildFileTreeBoundedLevels drives enough chunks through buildFileTree
+// to force it past a single level (chunk count > MaxListLine) and checks the
+// root's own Links slice stays small — i.e. earlier levels were flushed into
+// parents instead of one list growing with every chunk in the file.
+func TestBuildFileTreeBoundedLevels(t *testing.T) {
+	const chunkSize = 16
+	const numChunks = MaxListLine + 500
+	r := &counterReader{remaining: chunkSize * numChunks}
+	store := newMemKVStore()
+	h := sha256.New()
+
+	top, err := buildFileTree(NewFixedChunker(r, chunkSize), store, h, nil, nil)
+	if err != nil {
+		t.Fatalf("buildFileTree: %v", err)
+	}
+	if len(top.Links) >= numChunks {
+		t.Fatalf("root has %d links, expected level buffering to keep it well under %d", len(top.Links), numChunks)
+	}
+
+	var buf bytes.Buffer
+	if _, err := streamDfsData(store, *top, fixedHashPool{}, &buf); err != nil {
+		t.Fatalf("streamDfsData: %v", err)
+	}
+	want := &counterReader{remaining: chunkSize * numChunks}
+	wantBuf := make([]byte, chunkSize*numChunks)
+	want.Read(wantBuf)
+	if !bytes.Equal(buf.Bytes(), wantBuf) {
+		t.Fatalf("round-tripped content mismatch: got %d bytes, want %d", buf.Len(), len(wantBuf))
+	}
+}
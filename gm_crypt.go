@@ -0,0 +1,71 @@
+//go:build gmsm
+
+// Package merkledag's GM suite is opt-in: it depends on the third-party
+// github.com/tjfoc/gmsm module, which isn't a dependency of the default
+// build. Build with `-tags gmsm` (after `go get github.com/tjfoc/gmsm`) to
+// pull it in.
+package merkledag
+
+import (
+	"crypto/rand"
+	"hash"
+
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/sm4"
+)
+
+// GMCrypt is the optional Chinese national cryptographic ("GM") suite:
+// SM3 content hashing, SM4-CTR for symmetric blob encryption and SM2 for
+// signing and key wrapping. It satisfies Crypt so callers can swap it in
+// for SHA256AESRSA without touching Add/AddSigned/VerifyRoot.
+type GMCrypt struct{}
+
+func (GMCrypt) Hash(data []byte) []byte {
+	return sm3.Sm3Sum(data)
+}
+
+func (GMCrypt) NewHash() hash.Hash {
+	return sm3.New()
+}
+
+func (GMCrypt) Encrypt(data, key, iv []byte) ([]byte, error) {
+	return sm4.Sm4CTR(key, data, iv)
+}
+
+// Decrypt is identical to Encrypt: SM4-CTR is its own inverse.
+func (c GMCrypt) Decrypt(data, key, iv []byte) ([]byte, error) {
+	return c.Encrypt(data, key, iv)
+}
+
+func (GMCrypt) Sign(data, priKeyBytes []byte) ([]byte, error) {
+	priKey, err := sm2.ParsePKCS8UnecryptedPrivateKey(priKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return priKey.Sign(rand.Reader, data, nil)
+}
+
+func (GMCrypt) Verify(data, signature, pubKeyBytes []byte) bool {
+	pubKey, err := sm2.ParseSm2PublicKey(pubKeyBytes)
+	if err != nil {
+		return false
+	}
+	return pubKey.Verify(data, signature)
+}
+
+func (GMCrypt) EncryptE(data, pubKeyBytes []byte) ([]byte, error) {
+	pubKey, err := sm2.ParseSm2PublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.EncryptAsn1(data, rand.Reader)
+}
+
+func (GMCrypt) DecryptE(data, priKeyBytes []byte) ([]byte, error) {
+	priKey, err := sm2.ParsePKCS8UnecryptedPrivateKey(priKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return priKey.DecryptAsn1(data)
+}
@@ -0,0 +1,84 @@
+package merkledag
+
+import (
+	"encoding/json"
+	"hash"
+)
+
+// dirEntry is one child collected by handleDir before it decides whether
+// the directory is small enough to stay a flat TREE or needs HAMT sharding.
+type dirEntry struct {
+	link   *Link
+	marker string // BLOB, LINK or TREE — how a flat TREE's Data encodes this child
+}
+
+// hashNibble hashes name with h and returns the nibble (4 bits) at depth,
+// counting from the most significant nibble of the digest.
+func hashNibble(h hash.Hash, name string, depth int) int {
+	h.Reset()
+	h.Write([]byte(name))
+	sum := h.Sum(nil)
+	byteIdx := depth / 2
+	if byteIdx >= len(sum) {
+		byteIdx = len(sum) - 1
+	}
+	b := sum[byteIdx]
+	if depth%2 == 0 {
+		return int(b >> 4)
+	}
+	return int(b & 0x0f)
+}
+
+func popcount16(bitmap uint16) int {
+	count := 0
+	for bitmap != 0 {
+		count += int(bitmap & 1)
+		bitmap >>= 1
+	}
+	return count
+}
+
+// buildHamtShard splits entries into up to 16 buckets keyed by the nibble
+// at position depth of each entry's name digest, and recurses into a
+// further shard for any bucket that's still above ShardThreshold. The
+// result is a single Object marked HAMT, with a 16-bit bitmap of which
+// slots are populated followed by only those populated Links.
+func buildHamtShard(entries []*dirEntry, depth int, h hash.Hash, store KVStore) *Object {
+	var buckets [16][]*dirEntry
+	for _, e := range entries {
+		nibble := hashNibble(h, e.link.Name, depth)
+		buckets[nibble] = append(buckets[nibble], e)
+	}
+
+	shard := &Object{Data: []byte(HAMT)}
+	var bitmap uint16
+	for slot, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		bitmap |= 1 << uint(slot)
+		shard.Links = append(shard.Links, hamtChildLink(bucket, depth, h, store))
+	}
+	shard.Bitmap = bitmap
+	return shard
+}
+
+// hamtChildLink always wraps bucket in a container Object before linking it,
+// even when the bucket holds a single entry: descendHamt expects every shard
+// slot to point at a container it can scan by Name, not a bare leaf Link, so
+// a singleton shortcut here would make that one entry unreachable.
+func hamtChildLink(bucket []*dirEntry, depth int, h hash.Hash, store KVStore) *Link {
+	var child *Object
+	if len(bucket) > ShardThreshold {
+		child = buildHamtShard(bucket, depth+1, h, store)
+	} else {
+		child = &Object{}
+		for _, e := range bucket {
+			child.Links = append(child.Links, e.link)
+			child.Data = append(child.Data, []byte(e.marker)...)
+		}
+	}
+	putObjInStore(child, store, h)
+	jsonMarshal, _ := json.Marshal(child)
+	return &Link{Hash: computeHash(*child, jsonMarshal, h)}
+}
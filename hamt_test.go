@@ -0,0 +1,133 @@
+package merkledag
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"testing"
+)
+
+// countingKVStore wraps memKVStore to count Get calls, so a lookup's cost
+// can be asserted against log(n) instead of n.
+type countingKVStore struct {
+	*memKVStore
+	gets int
+}
+
+func (s *countingKVStore) Get(key []byte) ([]byte, error) {
+	s.gets++
+	return s.memKVStore.Get(key)
+}
+
+// TestHamtSingletonBucket targets the shape a uniform 100k-name spread won't
+// reach before recursion stops: a bucket that holds exactly one entry. That's
+// exactly the case hamtChildLink's old shortcut handled by returning the bare
+// leaf Link instead of wrapping it in a container, which made it unreadable
+// through descendHamt (which expects every shard slot to hold a container it
+// can scan by Name).
+func TestHamtSingletonBucket(t *testing.T) {
+	store := newMemKVStore()
+	h := sha256.New()
+
+	leaf := &Object{Data: []byte("content:solo")}
+	leafJSON, _ := json.Marshal(leaf)
+	putObjInStore(leaf, store, h)
+	leafHash := computeHash(*leaf, leafJSON, h)
+	bucket := []*dirEntry{{link: &Link{Name: "solo", Hash: leafHash}, marker: BLOB}}
+
+	childLink := hamtChildLink(bucket, 0, h, store)
+
+	child := getObjectByHash(store, childLink.Hash)
+	if child.Links == nil {
+		t.Fatalf("hamtChildLink returned a bare leaf instead of a container Object")
+	}
+
+	nibble := hashNibble(h, "solo", 0)
+	shard := &Object{Data: []byte(HAMT), Bitmap: 1 << uint(nibble), Links: []*Link{childLink}}
+	putObjInStore(shard, store, h)
+
+	got, _ := descendHamt(store, *shard, "solo", fixedHashPool{}, 0)
+	if string(got.Data) != "content:solo" {
+		t.Fatalf("descendHamt(%q) = %q, want %q", "solo", got.Data, "content:solo")
+	}
+}
+
+// buildLeafEntries stores one leaf Object per name and returns the dirEntry
+// handleDir would have produced for it.
+func buildLeafEntries(store KVStore, h hash.Hash, names []string) []*dirEntry {
+	entries := make([]*dirEntry, 0, len(names))
+	for _, name := range names {
+		leaf := &Object{Data: []byte("content:" + name)}
+		leafJSON, _ := json.Marshal(leaf)
+		putObjInStore(leaf, store, h)
+		link := &Link{Name: name, Hash: computeHash(*leaf, leafJSON, h)}
+		entries = append(entries, &dirEntry{link: link, marker: BLOB})
+	}
+	return entries
+}
+
+// TestHamtLookupIsBoundedNotLinear inserts 100k names into a HAMT shard and
+// checks that a lookup costs a handful of store reads (one per level of
+// recursion) rather than scanning anywhere close to all 100k entries, the
+// acceptance criterion the original request asked for.
+func TestHamtLookupIsBoundedNotLinear(t *testing.T) {
+	const n = 100000
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("name-%d", i)
+	}
+
+	base := newMemKVStore()
+	h := sha256.New()
+	entries := buildLeafEntries(base, h, names)
+	shard := buildHamtShard(entries, 0, h, base)
+	putObjInStore(shard, base, h)
+
+	store := &countingKVStore{memKVStore: base}
+	hp := fixedHashPool{}
+	for i := 0; i < n; i += n / 25 {
+		name := names[i]
+		store.gets = 0
+		got, link := descendHamt(store, *shard, name, hp, 0)
+		if link == nil || string(got.Data) != "content:"+name {
+			t.Fatalf("descendHamt(%q) = %q, want %q", name, got.Data, "content:"+name)
+		}
+		if store.gets > 10 {
+			t.Fatalf("descendHamt(%q) took %d store reads, expected O(log16(%d)) (<=10)", name, store.gets, n)
+		}
+	}
+}
+
+// TestHamtMatchesFlatTree checks a small directory (below ShardThreshold)
+// sharded through buildHamtShard returns exactly what the non-sharded flat
+// TREE format handleDir would have built for the same entries would return.
+func TestHamtMatchesFlatTree(t *testing.T) {
+	names := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+
+	store := newMemKVStore()
+	h := sha256.New()
+	entries := buildLeafEntries(store, h, names)
+
+	flat := &Object{}
+	for _, e := range entries {
+		flat.Links = append(flat.Links, e.link)
+		flat.Data = append(flat.Data, []byte(e.marker)...)
+	}
+	putObjInStore(flat, store, h)
+
+	shard := buildHamtShard(entries, 0, h, store)
+	putObjInStore(shard, store, h)
+
+	hp := fixedHashPool{}
+	for _, name := range names {
+		flatObj, flatLink := descendDir(store, *flat, name, hp)
+		hamtObj, hamtLink := descendHamt(store, *shard, name, hp, 0)
+		if flatLink == nil || hamtLink == nil {
+			t.Fatalf("lookup(%q): flat found=%v hamt found=%v", name, flatLink != nil, hamtLink != nil)
+		}
+		if string(flatObj.Data) != string(hamtObj.Data) {
+			t.Fatalf("lookup(%q): flat=%q hamt=%q", name, flatObj.Data, hamtObj.Data)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package merkledag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultPackSize is the pack size PackStore flushes at when the caller
+// doesn't specify one.
+const DefaultPackSize int64 = 64 * 1024 * 1024
+
+const packEntryObject = byte(1)
+
+// packLoc points at one stored Object inside a pack file.
+type packLoc struct {
+	packID uint32
+	offset int64
+	length int64
+}
+
+// PackStore is a KVStore that bundles objects into append-only *.pack files
+// instead of writing one entry per key, the same problem git solves with
+// packfiles. A companion *.idx file maps content-hash -> (packID, offset,
+// length) so Get never has to scan a pack to find an entry.
+type PackStore struct {
+	dir         string
+	maxPackSize int64
+
+	mu     sync.Mutex
+	index  map[string]packLoc
+	nextID uint32
+
+	cur     *os.File
+	curID   uint32
+	curSize int64
+}
+
+// OpenPackStore opens (or creates) a PackStore rooted at dir, loading every
+// existing *.idx file into memory. maxPackSize <= 0 uses DefaultPackSize.
+func OpenPackStore(dir string, maxPackSize int64) (*PackStore, error) {
+	if maxPackSize <= 0 {
+		maxPackSize = DefaultPackSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ps := &PackStore{dir: dir, maxPackSize: maxPackSize, index: make(map[string]packLoc)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".idx" {
+			continue
+		}
+		var id uint32
+		if _, err := fmt.Sscanf(e.Name(), "pack-%06d.idx", &id); err != nil {
+			continue
+		}
+		if id >= ps.nextID {
+			ps.nextID = id + 1
+		}
+		if err := ps.loadIndex(id); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+func packName(id uint32) string { return fmt.Sprintf("pack-%06d.pack", id) }
+func idxName(id uint32) string  { return fmt.Sprintf("pack-%06d.idx", id) }
+
+func (ps *PackStore) loadIndex(id uint32) error {
+	f, err := os.Open(filepath.Join(ps.dir, idxName(id)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		var keyLen uint32
+		if err := binary.Read(r, binary.BigEndian, &keyLen); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return err
+		}
+		var loc packLoc
+		loc.packID = id
+		if err := binary.Read(r, binary.BigEndian, &loc.offset); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &loc.length); err != nil {
+			return err
+		}
+		ps.index[string(key)] = loc
+	}
+}
+
+func (ps *PackStore) appendIndex(id uint32, key []byte, loc packLoc) error {
+	f, err := os.OpenFile(filepath.Join(ps.dir, idxName(id)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := binary.Write(f, binary.BigEndian, uint32(len(key))); err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.BigEndian, loc.offset); err != nil {
+		return err
+	}
+	return binary.Write(f, binary.BigEndian, loc.length)
+}
+
+func (ps *PackStore) currentPack() (*os.File, error) {
+	if ps.cur != nil {
+		return ps.cur, nil
+	}
+	ps.curID = ps.nextID
+	ps.nextID++
+	f, err := os.OpenFile(filepath.Join(ps.dir, packName(ps.curID)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	ps.cur = f
+	ps.curSize = 0
+	return f, nil
+}
+
+// Put appends value to the active pack and its index entry to that pack's
+// .idx file straight away; nothing is held back in memory. Once the pack
+// reaches maxPackSize it is closed, so the next Put starts a new one.
+func (ps *PackStore) Put(key, value []byte) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	f, err := ps.currentPack()
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 1, 11)
+	header[0] = packEntryObject
+	header = binary.AppendUvarint(header, uint64(len(value)))
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		return err
+	}
+
+	loc := packLoc{packID: ps.curID, offset: offset, length: int64(len(header) + len(value))}
+	ps.index[string(key)] = loc
+	if err := ps.appendIndex(ps.curID, key, loc); err != nil {
+		return err
+	}
+
+	ps.curSize += loc.length
+	if ps.curSize >= ps.maxPackSize {
+		if err := ps.cur.Close(); err != nil {
+			return err
+		}
+		ps.cur = nil
+	}
+	return nil
+}
+
+// Get consults the in-memory index and issues a single positioned read into
+// the pack that holds key.
+func (ps *PackStore) Get(key []byte) ([]byte, error) {
+	ps.mu.Lock()
+	loc, ok := ps.index[string(key)]
+	ps.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("packstore: key %x not found", key)
+	}
+	return ps.readEntry(loc)
+}
+
+// Has reports whether key is present without reading its value.
+func (ps *PackStore) Has(key []byte) (bool, error) {
+	ps.mu.Lock()
+	_, ok := ps.index[string(key)]
+	ps.mu.Unlock()
+	return ok, nil
+}
+
+func (ps *PackStore) readEntry(loc packLoc) ([]byte, error) {
+	f, err := os.Open(filepath.Join(ps.dir, packName(loc.packID)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, loc.length)
+	if _, err := f.ReadAt(buf, loc.offset); err != nil {
+		return nil, err
+	}
+	n, sz := binary.Uvarint(buf[1:])
+	if sz <= 0 {
+		return nil, fmt.Errorf("packstore: corrupt entry header at pack %d offset %d", loc.packID, loc.offset)
+	}
+	start := 1 + sz
+	return buf[start : start+int(n)], nil
+}
+
+// Repack drops every stored Object that isn't reachable from roots by
+// walking the transitive closure of Links, then rewrites the surviving
+// entries into a fresh generation of packs.
+func (ps *PackStore) Repack(roots [][]byte) error {
+	live, err := ps.liveSet(roots)
+	if err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	tmpDir := ps.dir + ".repack"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	fresh, err := OpenPackStore(tmpDir, ps.maxPackSize)
+	if err != nil {
+		return err
+	}
+	for key := range live {
+		value, err := ps.readEntry(ps.index[key])
+		if err != nil {
+			return err
+		}
+		if err := fresh.Put([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	if fresh.cur != nil {
+		fresh.cur.Close()
+	}
+
+	// ps.dir is about to be removed out from under ps.cur: close it first,
+	// or the open file descriptor leaks (and on some platforms the RemoveAll
+	// itself would fail while the file is still open).
+	if ps.cur != nil {
+		if err := ps.cur.Close(); err != nil {
+			return err
+		}
+		ps.cur = nil
+	}
+
+	if err := os.RemoveAll(ps.dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, ps.dir); err != nil {
+		return err
+	}
+
+	ps.index = fresh.index
+	ps.nextID = fresh.nextID
+	ps.cur = nil
+	ps.curSize = 0
+	return nil
+}
+
+func (ps *PackStore) liveSet(roots [][]byte) (map[string]bool, error) {
+	live := make(map[string]bool)
+	var walk func(key []byte) error
+	walk = func(key []byte) error {
+		k := string(key)
+		if live[k] {
+			return nil
+		}
+		live[k] = true
+		value, err := ps.Get(key)
+		if err != nil {
+			return err
+		}
+		var obj Object
+		if err := json.Unmarshal(value, &obj); err != nil {
+			return err
+		}
+		for _, link := range obj.Links {
+			if err := walk(link.Hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		if err := walk(root); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
+
+// Verify streams every entry, recomputes its hash the same way
+// putObjInStore does (via computeHash, so tree Objects are hashed from
+// their children rather than their own bytes), and reports every key whose
+// stored bytes no longer match it.
+func (ps *PackStore) Verify(newHash func() hash.Hash) ([][]byte, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var mismatches [][]byte
+	for key, loc := range ps.index {
+		value, err := ps.readEntry(loc)
+		if err != nil {
+			return nil, err
+		}
+		var obj Object
+		if err := json.Unmarshal(value, &obj); err != nil {
+			return nil, err
+		}
+		got := computeHash(obj, value, newHash())
+		if !bytes.Equal(got, []byte(key)) {
+			mismatches = append(mismatches, []byte(key))
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,158 @@
+package merkledag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func TestPackStorePutGetRoundTrip(t *testing.T) {
+	ps, err := OpenPackStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("OpenPackStore: %v", err)
+	}
+
+	entries := map[string][]byte{
+		"key-a": []byte("value a"),
+		"key-b": []byte("value b"),
+		"key-c": bytes.Repeat([]byte{0x42}, 10000),
+	}
+	for k, v := range entries {
+		if err := ps.Put([]byte(k), v); err != nil {
+			t.Fatalf("Put(%q): %v", k, err)
+		}
+	}
+	for k, want := range entries {
+		has, err := ps.Has([]byte(k))
+		if err != nil || !has {
+			t.Fatalf("Has(%q) = %v, %v", k, has, err)
+		}
+		got, err := ps.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("Get(%q): %v", k, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Get(%q) = %q, want %q", k, got, want)
+		}
+	}
+
+	if _, err := ps.Get([]byte("missing")); err == nil {
+		t.Fatal("Get(missing) returned no error")
+	}
+}
+
+// TestPackStoreReopenLoadsIndex forces pack rotation (maxPackSize=1 closes
+// the current pack after every Put) and then opens a second PackStore over
+// the same directory, checking it rebuilds its in-memory index purely from
+// the *.idx files on disk.
+func TestPackStoreReopenLoadsIndex(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := OpenPackStore(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenPackStore: %v", err)
+	}
+	want := map[string][]byte{"one": []byte("1111"), "two": []byte("2222"), "three": []byte("3333")}
+	for k, v := range want {
+		if err := ps.Put([]byte(k), v); err != nil {
+			t.Fatalf("Put(%q): %v", k, err)
+		}
+	}
+
+	reopened, err := OpenPackStore(dir, 1)
+	if err != nil {
+		t.Fatalf("reopen OpenPackStore: %v", err)
+	}
+	for k, v := range want {
+		got, err := reopened.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("reopened Get(%q): %v", k, err)
+		}
+		if !bytes.Equal(got, v) {
+			t.Fatalf("reopened Get(%q) = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestPackStoreRepackDropsOrphans(t *testing.T) {
+	ps, err := OpenPackStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("OpenPackStore: %v", err)
+	}
+	h := sha256.New()
+
+	leafA := &Object{Data: []byte("leaf a")}
+	leafAJSON, _ := json.Marshal(leafA)
+	leafAHash := computeHash(*leafA, leafAJSON, h)
+	if err := ps.Put(leafAHash, leafAJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := &Object{Data: []byte("unreferenced leaf")}
+	orphanJSON, _ := json.Marshal(orphan)
+	orphanHash := computeHash(*orphan, orphanJSON, h)
+	if err := ps.Put(orphanHash, orphanJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	root := &Object{Links: []*Link{{Name: "a", Hash: leafAHash}}}
+	rootJSON, _ := json.Marshal(root)
+	rootHash := computeHash(*root, rootJSON, h)
+	if err := ps.Put(rootHash, rootJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.Repack([][]byte{rootHash}); err != nil {
+		t.Fatalf("Repack: %v", err)
+	}
+
+	if has, _ := ps.Has(orphanHash); has {
+		t.Fatal("Repack kept an orphaned object reachable from no root")
+	}
+	for _, key := range [][]byte{rootHash, leafAHash} {
+		if has, _ := ps.Has(key); !has {
+			t.Fatalf("Repack dropped a live key %x", key)
+		}
+	}
+	got, err := ps.Get(leafAHash)
+	if err != nil || !bytes.Equal(got, leafAJSON) {
+		t.Fatalf("Get(leafA) after Repack = %q, %v", got, err)
+	}
+}
+
+func TestPackStoreVerifyDetectsTamper(t *testing.T) {
+	ps, err := OpenPackStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("OpenPackStore: %v", err)
+	}
+	h := sha256.New()
+
+	obj := &Object{Data: []byte("untampered")}
+	objJSON, _ := json.Marshal(obj)
+	key := computeHash(*obj, objJSON, h)
+	if err := ps.Put(key, objJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := ps.Verify(sha256.New)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("Verify found %d mismatches on an untampered store", len(mismatches))
+	}
+
+	tampered := &Object{Data: []byte("tampered")}
+	tamperedJSON, _ := json.Marshal(tampered)
+	if err := ps.Put(key, tamperedJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err = ps.Verify(sha256.New)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(mismatches) != 1 || !bytes.Equal(mismatches[0], key) {
+		t.Fatalf("Verify mismatches = %x, want exactly [%x]", mismatches, key)
+	}
+}